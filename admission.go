@@ -0,0 +1,104 @@
+package eirinix
+
+import (
+	"encoding/json"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// jsonPatchOperation is a single RFC 6902 JSONPatch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// admissionServer serves the mutating webhook HTTP endpoint, decoding each
+// AdmissionReview, running it through the Manager's Extensions and plugins,
+// and returning the resulting JSONPatch
+type admissionServer struct {
+	manager *manager
+}
+
+func (s *admissionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := s.manager.admit(review.Request)
+	review.Response = response
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// admit runs req's pod through FilterEiriniApps, the ExtensionSelector (the
+// MatchAnnotations part, since MatchLabels/MatchExpressions are already
+// enforced by the API server via the webhook's ObjectSelector), every
+// registered Extension, and finally every discovered plugin, and returns the
+// resulting AdmissionResponse
+func (m *manager) admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return admissionError(req.UID, err)
+	}
+
+	if m.options.FilterEiriniApps != nil && *m.options.FilterEiriniApps && pod.GetLabels()[LabelSourceType] != "APP" {
+		return allowed
+	}
+	if !m.options.ExtensionSelector.MatchesAnnotations(&pod) {
+		return allowed
+	}
+
+	mutated := &pod
+	for _, ext := range m.extensions {
+		var err error
+		mutated, err = ext.Handle(m, mutated)
+		if err != nil {
+			return admissionError(req.UID, err)
+		}
+	}
+
+	if m.plugins != nil {
+		var err error
+		mutated, err = m.plugins.Invoke(mutated)
+		if err != nil {
+			return admissionError(req.UID, err)
+		}
+	}
+
+	patch, err := json.Marshal([]jsonPatchOperation{
+		{Op: "replace", Path: "/metadata", Value: mutated.ObjectMeta},
+		{Op: "replace", Path: "/spec", Value: mutated.Spec},
+	})
+	if err != nil {
+		return admissionError(req.UID, err)
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		UID:       req.UID,
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+func admissionError(uid types.UID, err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result:  &metav1.Status{Message: err.Error()},
+	}
+}
@@ -0,0 +1,16 @@
+package eirinix
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Extension mutates a pod admitted through the webhook
+type Extension interface {
+	Handle(m Manager, pod *corev1.Pod) (*corev1.Pod, error)
+}
+
+// Watcher is dispatched Kubernetes watch events observed by the Manager
+type Watcher interface {
+	Handle(m Manager, e watch.Event)
+}
@@ -0,0 +1,265 @@
+package eirinix
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// extensionConfigGVR identifies the ExtensionConfig custom resource served
+// by the CRD in crds/extensionconfig.yaml / extensionConfigCRD()
+var extensionConfigGVR = schema.GroupVersionResource{
+	Group:    "eirinix.suse.io",
+	Version:  "v1",
+	Resource: "extensionconfigs",
+}
+
+// ExtensionConfigSpec is the spec of an ExtensionConfig custom resource. It
+// declares which extension to load and how to wire it into the mutating
+// webhook, so extensions can be registered/removed without restarting the
+// process
+type ExtensionConfigSpec struct {
+	// Extension is the name of an extension registered with
+	// RegisterBuiltinExtension. Only that registry is looked up: a plugin's
+	// name is rejected even when PluginDir is configured on the Manager
+	Extension string `json:"extension"`
+
+	// Selector scopes which pods this extension is invoked for
+	Selector ExtensionSelector `json:"selector,omitempty"`
+
+	// WebhookPath is the path this extension is served under
+	WebhookPath string `json:"webhookPath"`
+
+	// FailurePolicy is one of "Ignore" or "Fail", as in
+	// admissionregistrationv1.FailurePolicyType
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+
+	// ReinvocationPolicy is one of "Never" or "IfNeeded", as in
+	// admissionregistrationv1.ReinvocationPolicyType
+	ReinvocationPolicy string `json:"reinvocationPolicy,omitempty"`
+
+	// Config is an arbitrary JSON blob passed through to the loaded
+	// extension, e.g. to parameterize a generic sidecar injector
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// ExtensionConfig is the eirinix.suse.io/v1 ExtensionConfig custom resource
+type ExtensionConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ExtensionConfigSpec `json:"spec"`
+}
+
+// extensionConfigReconciler watches ExtensionConfig custom resources and
+// adds/removes registered extensions at runtime, keeping the
+// MutatingWebhookConfiguration in sync
+type extensionConfigReconciler struct {
+	manager *manager
+	loaded  map[string]Extension
+	cancel  context.CancelFunc
+}
+
+func newExtensionConfigReconciler(m *manager) *extensionConfigReconciler {
+	return &extensionConfigReconciler{manager: m, loaded: map[string]Extension{}}
+}
+
+// Start ensures the ExtensionConfig CRD is registered, then begins watching
+// ExtensionConfig resources and reconciling the Manager's registered
+// extensions and webhook configuration as they are added, updated or deleted
+func (r *extensionConfigReconciler) Start(ctx context.Context, c *clients) error {
+	if c == nil {
+		return errors.New("ExtensionConfig reconciler requires a Kubernetes client")
+	}
+
+	if err := ensureExtensionConfigCRD(ctx, c.apiextensions); err != nil {
+		return errors.Wrap(err, "registering ExtensionConfig CRD")
+	}
+
+	watcher, err := c.dynamic.Resource(extensionConfigGVR).Namespace(r.manager.options.Namespace).Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "watching ExtensionConfig resources")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	go r.run(ctx, watcher)
+	return nil
+}
+
+func (r *extensionConfigReconciler) run(ctx context.Context, watcher watch.Interface) {
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			u, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			var cfg ExtensionConfig
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &cfg); err != nil {
+				continue
+			}
+			_ = r.Reconcile(&cfg, event.Type == watch.Deleted)
+		}
+	}
+}
+
+// Stop cancels the ExtensionConfig watch
+func (r *extensionConfigReconciler) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Reconcile is called for every ExtensionConfig add/update/delete event. It
+// removes any extension previously loaded for this CR, then — unless the CR
+// was deleted — loads and registers its replacement, and refreshes the
+// MutatingWebhookConfiguration to match
+func (r *extensionConfigReconciler) Reconcile(cfg *ExtensionConfig, deleted bool) error {
+	key := cfg.Namespace + "/" + cfg.Name
+
+	if old, ok := r.loaded[key]; ok {
+		r.manager.RemoveExtension(old)
+		delete(r.loaded, key)
+		delete(r.manager.extraWebhookRules, key)
+	}
+
+	if deleted {
+		return r.manager.registerWebhook(context.Background())
+	}
+
+	ext, err := loadExtension(cfg.Spec)
+	if err != nil {
+		return err
+	}
+	r.loaded[key] = ext
+	r.manager.AddExtension(ext)
+
+	if r.manager.extraWebhookRules == nil {
+		r.manager.extraWebhookRules = map[string]webhookRule{}
+	}
+	r.manager.extraWebhookRules[key] = webhookRule{
+		name:               cfg.Name,
+		path:               cfg.Spec.WebhookPath,
+		selector:           cfg.Spec.Selector,
+		failurePolicy:      cfg.Spec.failurePolicy(),
+		reinvocationPolicy: cfg.Spec.reinvocationPolicy(),
+	}
+
+	return r.manager.registerWebhook(context.Background())
+}
+
+// builtinExtensions is the registry of Extension factories ExtensionConfig's
+// Extension field can refer to by name
+var builtinExtensions = map[string]func() Extension{}
+
+// RegisterBuiltinExtension makes an Extension available to ExtensionConfig
+// custom resources under the given name
+func RegisterBuiltinExtension(name string, factory func() Extension) {
+	builtinExtensions[name] = factory
+}
+
+func loadExtension(spec ExtensionConfigSpec) (Extension, error) {
+	factory, ok := builtinExtensions[spec.Extension]
+	if !ok {
+		return nil, errors.Errorf("no built-in extension registered under name %q", spec.Extension)
+	}
+	return &extensionConfigExtension{spec: spec, impl: factory()}, nil
+}
+
+// extensionConfigExtension backs an ExtensionConfig's Extension field by
+// looking the named extension up in the built-in registry and delegating to
+// it, applying the CR's Selector before invoking it
+type extensionConfigExtension struct {
+	spec ExtensionConfigSpec
+	impl Extension
+}
+
+func (e *extensionConfigExtension) Handle(m Manager, pod *corev1.Pod) (*corev1.Pod, error) {
+	if e.impl == nil || !e.spec.Selector.Matches(pod) {
+		return pod, nil
+	}
+	return e.impl.Handle(m, pod)
+}
+
+// failurePolicy translates the spec's string FailurePolicy into the typed
+// admissionregistrationv1 value, defaulting to Fail
+func (s ExtensionConfigSpec) failurePolicy() admissionregistrationv1.FailurePolicyType {
+	if s.FailurePolicy == string(admissionregistrationv1.Ignore) {
+		return admissionregistrationv1.Ignore
+	}
+	return admissionregistrationv1.Fail
+}
+
+// reinvocationPolicy translates the spec's string ReinvocationPolicy into the
+// typed admissionregistrationv1 value, defaulting to Never
+func (s ExtensionConfigSpec) reinvocationPolicy() admissionregistrationv1.ReinvocationPolicyType {
+	if s.ReinvocationPolicy == string(admissionregistrationv1.IfNeeded) {
+		return admissionregistrationv1.IfNeeded
+	}
+	return admissionregistrationv1.Never
+}
+
+// extensionConfigCRD returns the CustomResourceDefinition backing the
+// ExtensionConfig custom resource, mirroring crds/extensionconfig.yaml
+func extensionConfigCRD() *apiextensionsv1.CustomResourceDefinition {
+	preserveUnknownFields := true
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "extensionconfigs.eirinix.suse.io",
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "eirinix.suse.io",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "extensionconfigs",
+				Singular: "extensionconfig",
+				Kind:     "ExtensionConfig",
+				ListKind: "ExtensionConfigList",
+			},
+			Scope: apiextensionsv1.NamespaceScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name:    "v1",
+					Served:  true,
+					Storage: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{
+						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+							Type:                   "object",
+							XPreserveUnknownFields: &preserveUnknownFields,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// ensureExtensionConfigCRD creates the ExtensionConfig CRD if it doesn't
+// already exist. It is idempotent so every Manager with
+// ReconcileExtensionConfigs enabled can call it on Start without racing
+// others over who gets to create it
+func ensureExtensionConfigCRD(ctx context.Context, client apiextensionsclientset.Interface) error {
+	crd := extensionConfigCRD()
+	_, err := client.ApiextensionsV1().CustomResourceDefinitions().Create(ctx, crd, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
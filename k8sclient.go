@@ -0,0 +1,56 @@
+package eirinix
+
+import (
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clients groups every Kubernetes client the Manager needs: a typed
+// clientset for the webhook configuration, a dynamic client for the
+// ExtensionConfig custom resource, and an apiextensions clientset to ensure
+// its CRD is registered
+type clients struct {
+	kube          kubernetes.Interface
+	dynamic       dynamic.Interface
+	apiextensions apiextensionsclientset.Interface
+}
+
+// restConfig builds a *rest.Config from ManagerOptions.KubeConfig, falling
+// back to in-cluster configuration when it is empty, matching how every
+// other cf-operator-family controller resolves its client config
+func restConfig(opts ManagerOptions) (*rest.Config, error) {
+	if opts.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", opts.KubeConfig)
+}
+
+// newClients builds the clientsets the Manager uses to register its webhook
+// configuration and, when ReconcileExtensionConfigs is enabled, to watch and
+// register the ExtensionConfig CRD
+func newClients(opts ManagerOptions) (*clients, error) {
+	cfg, err := restConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kube, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	apiext, err := apiextensionsclientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clients{kube: kube, dynamic: dyn, apiextensions: apiext}, nil
+}
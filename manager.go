@@ -0,0 +1,189 @@
+// Package eirinix provides a Manager that registers mutating admission
+// webhook Extensions against Eirini application pods running on Kubernetes.
+package eirinix
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// LabelSourceType is the pod label eiriniX uses to recognize Eirini-managed
+// application pods
+const LabelSourceType = "source_type"
+
+// ManagerOptions represents the configuration used to create a new Manager
+type ManagerOptions struct {
+	Namespace        string
+	Host             string
+	Port             int32
+	KubeConfig       string
+	ServiceName      string
+	WebhookNamespace string
+
+	// FilterEiriniApps, when set, toggles whether only Eirini application
+	// pods are mutated (true) or every pod is mutated (false)
+	FilterEiriniApps *bool
+
+	// RegisterWebHook, when set to false, skips (re-)registering the
+	// MutatingWebhookConfiguration on Start
+	RegisterWebHook *bool
+
+	// ExtensionSelector further scopes which pods are mutated, on top of
+	// FilterEiriniApps, using label/annotation/expression matching
+	ExtensionSelector ExtensionSelector
+
+	// ReconcileExtensionConfigs enables the ExtensionConfig CRD reconciler,
+	// allowing extensions to be registered/removed at runtime
+	ReconcileExtensionConfigs bool
+
+	// PluginDir, when set, enables the out-of-process plugin subsystem: the
+	// Manager scans this directory for plugin executables/sockets to invoke
+	// alongside in-process Extensions
+	PluginDir string
+}
+
+// Manager registers Extensions and Watchers and serves the mutating webhook
+// endpoint. It starts no Kubernetes watch of its own, so registered Watchers
+// are not handed any events
+type Manager interface {
+	AddExtension(Extension)
+	RemoveExtension(Extension)
+	AddWatcher(Watcher)
+	Start() error
+	Stop()
+	Options() ManagerOptions
+}
+
+type manager struct {
+	options    ManagerOptions
+	extensions []Extension
+	watchers   []Watcher
+	plugins    *pluginRegistry
+	reconciler *extensionConfigReconciler
+
+	clients *clients
+	server  *http.Server
+
+	// extraWebhookRules holds the webhook entries contributed by
+	// ExtensionConfig CRs the reconciler has loaded, keyed by CR namespace/name
+	extraWebhookRules map[string]webhookRule
+}
+
+// NewManager creates a Manager out of the given ManagerOptions
+func NewManager(options ManagerOptions) Manager {
+	m := &manager{options: options}
+	if options.PluginDir != "" {
+		m.plugins = newPluginRegistry(options.PluginDir)
+	}
+	if options.ReconcileExtensionConfigs {
+		m.reconciler = newExtensionConfigReconciler(m)
+	}
+	return m
+}
+
+// Options returns the ManagerOptions the Manager was created with
+func (m *manager) Options() ManagerOptions {
+	return m.options
+}
+
+// AddExtension registers an Extension to be invoked by the mutating webhook
+func (m *manager) AddExtension(e Extension) {
+	m.extensions = append(m.extensions, e)
+}
+
+// RemoveExtension unregisters an Extension previously added with
+// AddExtension, e.g. when the ExtensionConfig reconciler observes its CR
+// being deleted or replaced
+func (m *manager) RemoveExtension(e Extension) {
+	kept := m.extensions[:0]
+	for _, existing := range m.extensions {
+		if existing != e {
+			kept = append(kept, existing)
+		}
+	}
+	m.extensions = kept
+}
+
+// AddWatcher registers a Watcher to be dispatched Kubernetes watch events
+func (m *manager) AddWatcher(w Watcher) {
+	m.watchers = append(m.watchers, w)
+}
+
+// Start registers the webhook configuration (unless disabled), starts the
+// plugin registry and the ExtensionConfig reconciler (when enabled) and
+// begins serving admission requests on options.Host:options.Port
+func (m *manager) Start() error {
+	ctx := context.Background()
+
+	registerWebhook := m.options.RegisterWebHook == nil || *m.options.RegisterWebHook
+	if registerWebhook || m.reconciler != nil {
+		clients, err := newClients(m.options)
+		if err != nil {
+			return err
+		}
+		m.clients = clients
+	}
+	if registerWebhook {
+		if err := m.registerWebhook(ctx); err != nil {
+			return err
+		}
+	}
+
+	if m.plugins != nil {
+		if err := m.plugins.Discover(); err != nil {
+			return err
+		}
+	}
+	if m.reconciler != nil {
+		if err := m.reconciler.Start(ctx, m.clients); err != nil {
+			return err
+		}
+	}
+
+	m.server = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", m.options.Host, m.options.Port),
+		Handler: &admissionServer{manager: m},
+	}
+	go m.server.ListenAndServe()
+
+	return nil
+}
+
+// Stop tears down the webhook server and the ExtensionConfig reconciler's
+// watch, when running
+func (m *manager) Stop() {
+	if m.reconciler != nil {
+		m.reconciler.Stop()
+	}
+	if m.server != nil {
+		m.server.Close()
+	}
+}
+
+// registerWebhook builds and applies the MutatingWebhookConfiguration out of
+// the Manager's static ExtensionSelector plus every rule the ExtensionConfig
+// reconciler has contributed so far, translating MatchLabels/MatchExpressions
+// into the webhook's ObjectSelector; MatchAnnotations has no such equivalent
+// and is enforced in-process by admit() instead
+func (m *manager) registerWebhook(ctx context.Context) error {
+	rules := []webhookRule{{
+		name:     "mutate",
+		path:     "/",
+		selector: m.options.ExtensionSelector,
+	}}
+	for _, rule := range m.extraWebhookRules {
+		rules = append(rules, rule)
+	}
+
+	cfg := buildWebhookConfiguration(m.options, rules)
+	return applyWebhookConfiguration(ctx, m.clients.kube, cfg)
+}
+
+func (m *manager) dispatch(e watch.Event) {
+	for _, w := range m.watchers {
+		w.Handle(m, e)
+	}
+}
@@ -0,0 +1,159 @@
+package eirinix
+
+import (
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ClusterWatcher is the cluster-aware counterpart of Watcher: a
+// MultiClusterManager dispatches to ClusterWatcher.Handle when a registered
+// Watcher also implements it, passing along the identifier of the cluster the
+// event came from
+type ClusterWatcher interface {
+	Handle(m Manager, clusterID string, e watch.Event)
+}
+
+// ClusterEvent pairs a watch.Event with the identifier of the cluster it was
+// observed on, delivered on MultiClusterManager's unified Events channel
+type ClusterEvent struct {
+	ClusterID string
+	Event     watch.Event
+}
+
+// MultiClusterManager fans a set of per-cluster Managers out behind a single
+// Start/Stop lifecycle, so the same Extensions and Watchers can be registered
+// against every cluster at once
+type MultiClusterManager interface {
+	AddExtension(Extension)
+	AddWatcher(Watcher)
+	Start() error
+	Stop()
+	// Managers returns the underlying per-cluster Managers, keyed by cluster
+	// identifier ("cluster-<index>" of the ManagerOptions it was created from,
+	// or the id given to NewMultiClusterManagerWithIDs)
+	Managers() map[string]Manager
+	// Events returns the unified channel every cluster's watch events are
+	// forwarded to, tagged with the ClusterID they came from
+	Events() <-chan ClusterEvent
+}
+
+type multiClusterManager struct {
+	managers map[string]Manager
+	events   chan ClusterEvent
+}
+
+// eventsChannelBufferSize bounds how many events can be queued on the unified
+// Events channel before a slow consumer starts blocking cluster dispatch
+const eventsChannelBufferSize = 64
+
+// NewMultiClusterManager creates a MultiClusterManager with one Manager per
+// given ManagerOptions. Cluster identifiers are derived from the position of
+// each ManagerOptions in the slice ("cluster-0", "cluster-1", ...); use
+// NewMultiClusterManagerWithIDs to assign meaningful identifiers instead
+func NewMultiClusterManager(opts ...ManagerOptions) MultiClusterManager {
+	ids := make([]string, len(opts))
+	for i := range opts {
+		ids[i] = clusterIndexID(i)
+	}
+	return NewMultiClusterManagerWithIDs(ids, opts)
+}
+
+// NewMultiClusterManagerWithIDs creates a MultiClusterManager with one
+// Manager per ManagerOptions, identified by the corresponding entry in ids.
+// Every cluster's watch events are additionally forwarded to the unified
+// channel returned by Events
+func NewMultiClusterManagerWithIDs(ids []string, opts []ManagerOptions) MultiClusterManager {
+	managers := make(map[string]Manager, len(opts))
+	for i, o := range opts {
+		managers[ids[i]] = NewManager(o)
+	}
+
+	m := &multiClusterManager{managers: managers, events: make(chan ClusterEvent, eventsChannelBufferSize)}
+	for id, mgr := range managers {
+		mgr.AddWatcher(clusterWatcherAdapter{clusterID: id, watcher: &channelClusterWatcher{events: m.events}})
+	}
+	return m
+}
+
+func clusterIndexID(i int) string {
+	return "cluster-" + strconv.Itoa(i)
+}
+
+// Managers returns the underlying per-cluster Managers, keyed by cluster ID
+func (m *multiClusterManager) Managers() map[string]Manager {
+	return m.managers
+}
+
+// Events returns the unified channel every cluster's watch events are
+// forwarded to, tagged with the ClusterID they came from
+func (m *multiClusterManager) Events() <-chan ClusterEvent {
+	return m.events
+}
+
+// AddExtension registers the Extension against every cluster's Manager
+func (m *multiClusterManager) AddExtension(e Extension) {
+	for _, mgr := range m.managers {
+		mgr.AddExtension(e)
+	}
+}
+
+// AddWatcher registers the Watcher against every cluster's Manager. If the
+// Watcher also implements ClusterWatcher, its cluster-aware Handle is
+// dispatched instead of the plain one, so handlers can disambiguate which
+// cluster an event came from
+func (m *multiClusterManager) AddWatcher(w Watcher) {
+	for id, mgr := range m.managers {
+		if cw, ok := w.(ClusterWatcher); ok {
+			mgr.AddWatcher(clusterWatcherAdapter{clusterID: id, watcher: cw})
+			continue
+		}
+		mgr.AddWatcher(w)
+	}
+}
+
+// Start starts every per-cluster Manager, stopping whichever already started
+// if any of them fails
+func (m *multiClusterManager) Start() error {
+	started := make([]Manager, 0, len(m.managers))
+	for _, mgr := range m.managers {
+		if err := mgr.Start(); err != nil {
+			for _, s := range started {
+				s.Stop()
+			}
+			return err
+		}
+		started = append(started, mgr)
+	}
+	return nil
+}
+
+// Stop stops every per-cluster Manager
+func (m *multiClusterManager) Stop() {
+	for _, mgr := range m.managers {
+		mgr.Stop()
+	}
+}
+
+// clusterWatcherAdapter adapts a ClusterWatcher to the plain Watcher
+// interface a per-cluster Manager expects, pinning in the cluster ID it was
+// registered under
+type clusterWatcherAdapter struct {
+	clusterID string
+	watcher   ClusterWatcher
+}
+
+func (a clusterWatcherAdapter) Handle(m Manager, e watch.Event) {
+	a.watcher.Handle(m, a.clusterID, e)
+}
+
+// channelClusterWatcher is the internal ClusterWatcher every per-cluster
+// Manager gets registered with, forwarding every event it observes onto the
+// MultiClusterManager's unified Events channel
+type channelClusterWatcher struct {
+	events chan<- ClusterEvent
+}
+
+func (w *channelClusterWatcher) Handle(m Manager, clusterID string, e watch.Event) {
+	w.events <- ClusterEvent{ClusterID: clusterID, Event: e}
+}
@@ -0,0 +1,487 @@
+package eirinix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// pluginManifest mirrors proto/extension.proto's ManifestResponse: what a
+// plugin reports about itself when probed
+type pluginManifest struct {
+	Name                       string            `json:"name"`
+	SupportedAdmissionVersions []string          `json:"supported_admission_versions"`
+	MatchLabels                map[string]string `json:"match_labels,omitempty"`
+	MatchAnnotations           map[string]string `json:"match_annotations,omitempty"`
+}
+
+// pluginRequest is the envelope exchanged with a plugin: over exec it is
+// written to stdin (Command implied by the subcommand argument instead), over
+// a unix socket it is newline-delimited JSON and Command picks the RPC
+type pluginRequest struct {
+	Command string          `json:"command,omitempty"`
+	Pod     json.RawMessage `json:"pod,omitempty"`
+}
+
+// pluginResponse mirrors proto/extension.proto's AdmissionResponse, plus the
+// manifest fields so a single envelope can carry either RPC's reply over the
+// socket transport
+type pluginResponse struct {
+	pluginManifest
+
+	Patch json.RawMessage `json:"patch,omitempty"`
+	Pod   json.RawMessage `json:"pod,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// ExtensionService is the Go SDK contract a plugin implements, mirroring
+// proto/extension.proto's ExtensionService. WrapExtension/ServePlugin let a
+// plugin written in Go implement it by wrapping a plain Extension, so a
+// binary built with the SDK behaves like an in-process Extension to its
+// author while still speaking the out-of-process protocol to the Manager
+type ExtensionService interface {
+	Manifest() (name string, selector ExtensionSelector, supportedVersions []string)
+	Mutate(pod *corev1.Pod) (*corev1.Pod, error)
+}
+
+// extensionServiceAdapter adapts a plain Extension to ExtensionService, for
+// the Go SDK a standalone plugin binary links against
+type extensionServiceAdapter struct {
+	name      string
+	selector  ExtensionSelector
+	extension Extension
+	manager   Manager
+}
+
+// WrapExtension returns an ExtensionService that runs extension in-process,
+// for use inside a standalone plugin binary's main(): the binary links the
+// eirinix Go SDK, wraps its existing Extension, and calls ServePlugin (exec
+// transport) or ServePluginSocket (unix-socket transport) to speak the
+// out-of-process protocol without changing how the Extension itself is
+// written
+func WrapExtension(name string, selector ExtensionSelector, extension Extension, m Manager) ExtensionService {
+	return &extensionServiceAdapter{name: name, selector: selector, extension: extension, manager: m}
+}
+
+func (a *extensionServiceAdapter) Manifest() (string, ExtensionSelector, []string) {
+	return a.name, a.selector, []string{"admission.k8s.io/v1"}
+}
+
+func (a *extensionServiceAdapter) Mutate(pod *corev1.Pod) (*corev1.Pod, error) {
+	return a.extension.Handle(a.manager, pod)
+}
+
+func manifestResponse(svc ExtensionService) pluginResponse {
+	name, selector, versions := svc.Manifest()
+	return pluginResponse{pluginManifest: pluginManifest{
+		Name:                       name,
+		SupportedAdmissionVersions: versions,
+		MatchLabels:                selector.MatchLabels,
+		MatchAnnotations:           selector.MatchAnnotations,
+	}}
+}
+
+func mutateResponse(svc ExtensionService, podJSON json.RawMessage) pluginResponse {
+	var pod corev1.Pod
+	if err := json.Unmarshal(podJSON, &pod); err != nil {
+		return pluginResponse{Error: errors.Wrap(err, "decoding admitted pod").Error()}
+	}
+	mutated, err := svc.Mutate(&pod)
+	if err != nil {
+		return pluginResponse{Error: err.Error()}
+	}
+	podOut, err := json.Marshal(mutated)
+	if err != nil {
+		return pluginResponse{Error: errors.Wrap(err, "encoding mutated pod").Error()}
+	}
+	return pluginResponse{Pod: podOut}
+}
+
+// ServePlugin runs svc as a CNI-style exec plugin: it inspects os.Args for
+// the "manifest"/"mutate" subcommand dispatched by the Manager's plugin
+// registry, reads an AdmissionRequest from stdin when mutating, and writes
+// the response to stdout. A plugin built with the Go SDK calls this from
+// main() instead of implementing the wire protocol itself
+func ServePlugin(svc ExtensionService) error {
+	if len(os.Args) < 2 {
+		return errors.New("usage: plugin <manifest|mutate>")
+	}
+
+	switch os.Args[1] {
+	case "manifest":
+		return json.NewEncoder(os.Stdout).Encode(manifestResponse(svc))
+	case "mutate":
+		var req pluginRequest
+		if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+			return errors.Wrap(err, "decoding admission request")
+		}
+		return json.NewEncoder(os.Stdout).Encode(mutateResponse(svc, req.Pod))
+	default:
+		return errors.Errorf("unknown plugin subcommand %q", os.Args[1])
+	}
+}
+
+// ServePluginSocket runs svc as a long-running plugin listening on the given
+// unix socket path, handling one newline-delimited JSON pluginRequest per
+// connection and replying with a pluginResponse. This is the unix-socket
+// counterpart to ServePlugin for plugins that want to stay warm across many
+// admission requests instead of being exec'd once per pod
+func ServePluginSocket(path string, svc ExtensionService) error {
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return errors.Wrapf(err, "listening on plugin socket %s", path)
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go servePluginConn(conn, svc)
+	}
+}
+
+func servePluginConn(conn net.Conn, svc ExtensionService) {
+	defer conn.Close()
+
+	var req pluginRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(pluginResponse{Error: err.Error()})
+		return
+	}
+
+	var resp pluginResponse
+	switch req.Command {
+	case "manifest":
+		resp = manifestResponse(svc)
+	case "mutate":
+		resp = mutateResponse(svc, req.Pod)
+	default:
+		resp = pluginResponse{Error: "unknown command " + req.Command}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// pluginHandle is a discovered plugin and its probed manifest. Exactly one of
+// execPath/socketPath is set, selecting which transport Invoke uses
+type pluginHandle struct {
+	execPath   string
+	socketPath string
+	manifest   pluginManifest
+}
+
+// pluginRegistry scans ManagerOptions.PluginDir for plugin executables and
+// unix sockets, probes each for its manifest and invokes matching ones per
+// admission request, modeled on the CNI plugin discovery/invocation pattern
+type pluginRegistry struct {
+	dir     string
+	plugins []pluginHandle
+}
+
+func newPluginRegistry(dir string) *pluginRegistry {
+	return &pluginRegistry{dir: dir}
+}
+
+// Discover scans the plugin directory for executables and unix sockets and
+// probes each of them for its manifest
+func (r *pluginRegistry) Discover() error {
+	entries, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return errors.Wrap(err, "reading plugin directory")
+	}
+
+	var plugins []pluginHandle
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(r.dir, entry.Name())
+
+		if entry.Mode()&os.ModeSocket != 0 {
+			manifest, err := probeSocketPlugin(path)
+			if err != nil {
+				return errors.Wrapf(err, "probing plugin socket %s", path)
+			}
+			plugins = append(plugins, pluginHandle{socketPath: path, manifest: manifest})
+			continue
+		}
+
+		if entry.Mode()&0111 == 0 {
+			continue
+		}
+		manifest, err := probeExecPlugin(path)
+		if err != nil {
+			return errors.Wrapf(err, "probing plugin %s", path)
+		}
+		plugins = append(plugins, pluginHandle{execPath: path, manifest: manifest})
+	}
+	r.plugins = plugins
+	return nil
+}
+
+func probeExecPlugin(path string) (pluginManifest, error) {
+	out, err := exec.Command(path, "manifest").Output()
+	if err != nil {
+		return pluginManifest{}, err
+	}
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return pluginManifest{}, errors.Wrap(err, "decoding plugin manifest")
+	}
+	return resp.pluginManifest, nil
+}
+
+func probeSocketPlugin(path string) (pluginManifest, error) {
+	resp, err := callSocketPlugin(path, pluginRequest{Command: "manifest"})
+	if err != nil {
+		return pluginManifest{}, err
+	}
+	return resp.pluginManifest, nil
+}
+
+func callSocketPlugin(path string, req pluginRequest) (pluginResponse, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return pluginResponse{}, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return pluginResponse{}, errors.Wrap(err, "sending request to plugin socket")
+	}
+
+	var resp pluginResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return pluginResponse{}, errors.Wrap(err, "reading response from plugin socket")
+	}
+	return resp, nil
+}
+
+// Invoke runs every discovered plugin whose manifest selector matches pod,
+// applying each plugin's mutation (as a full replacement pod or an RFC 6902
+// JSONPatch) in turn, and returns the resulting pod
+func (r *pluginRegistry) Invoke(pod *corev1.Pod) (*corev1.Pod, error) {
+	for _, plugin := range r.plugins {
+		selector := ExtensionSelector{MatchLabels: plugin.manifest.MatchLabels, MatchAnnotations: plugin.manifest.MatchAnnotations}
+		if !selector.Matches(pod) {
+			continue
+		}
+
+		podJSON, err := json.Marshal(pod)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding pod for plugin invocation")
+		}
+
+		resp, err := invokePlugin(plugin, podJSON)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invoking plugin %s", pluginName(plugin))
+		}
+		if resp.Error != "" {
+			return nil, errors.Errorf("plugin %s: %s", pluginName(plugin), resp.Error)
+		}
+
+		var mutated corev1.Pod
+		switch {
+		case len(resp.Pod) > 0:
+			if err := json.Unmarshal(resp.Pod, &mutated); err != nil {
+				return nil, errors.Wrapf(err, "decoding mutated pod from plugin %s", pluginName(plugin))
+			}
+			pod = &mutated
+		case len(resp.Patch) > 0:
+			patched, err := applyJSONPatch(podJSON, resp.Patch)
+			if err != nil {
+				return nil, errors.Wrapf(err, "applying patch from plugin %s", pluginName(plugin))
+			}
+			if err := json.Unmarshal(patched, &mutated); err != nil {
+				return nil, errors.Wrapf(err, "decoding patched pod from plugin %s", pluginName(plugin))
+			}
+			pod = &mutated
+		}
+	}
+
+	return pod, nil
+}
+
+func pluginName(h pluginHandle) string {
+	if h.socketPath != "" {
+		return h.socketPath
+	}
+	return h.execPath
+}
+
+func invokePlugin(h pluginHandle, podJSON json.RawMessage) (pluginResponse, error) {
+	if h.socketPath != "" {
+		return callSocketPlugin(h.socketPath, pluginRequest{Command: "mutate", Pod: podJSON})
+	}
+
+	reqJSON, err := json.Marshal(pluginRequest{Pod: podJSON})
+	if err != nil {
+		return pluginResponse{}, errors.Wrap(err, "encoding admission request")
+	}
+
+	cmd := exec.Command(h.execPath, "mutate")
+	cmd.Stdin = bytes.NewReader(reqJSON)
+	out, err := cmd.Output()
+	if err != nil {
+		return pluginResponse{}, err
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return pluginResponse{}, errors.Wrap(err, "decoding plugin response")
+	}
+	return resp, nil
+}
+
+// jsonPatchOp is a single RFC 6902 JSONPatch operation
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies the "add"/"replace"/"remove" subset of RFC 6902
+// that a Pod-mutating plugin needs to a JSON document, returning the patched
+// document. Patch targets are resolved against the generic JSON object tree
+// rather than the typed corev1.Pod, since a patch may add fields (e.g. new
+// annotations) the typed struct doesn't need to know about ahead of time
+func applyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, errors.Wrap(err, "decoding JSONPatch")
+	}
+
+	var tree interface{}
+	if err := json.Unmarshal(doc, &tree); err != nil {
+		return nil, errors.Wrap(err, "decoding patch target")
+	}
+
+	for _, op := range ops {
+		var value interface{}
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, errors.Wrap(err, "decoding JSONPatch value")
+			}
+		}
+
+		segments := jsonPointerSegments(op.Path)
+		var err error
+		tree, err = applyJSONPatchOp(tree, segments, op.Op, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "applying JSONPatch op %q at %q", op.Op, op.Path)
+		}
+	}
+
+	return json.Marshal(tree)
+}
+
+// applyJSONPatchOp applies a single operation to node, returning node with
+// the operation applied. Containers are reconstructed bottom-up so mutating
+// a deeply-nested array element (e.g. /spec/containers/0/image) correctly
+// propagates back up to the root
+func applyJSONPatchOp(node interface{}, segments []string, op string, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+		return value, nil
+	}
+
+	seg := segments[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(segments) == 1 {
+			switch op {
+			case "add", "replace":
+				n[seg] = value
+			case "remove":
+				delete(n, seg)
+			default:
+				return nil, errors.Errorf("unsupported JSONPatch operation %q", op)
+			}
+			return n, nil
+		}
+		child, ok := n[seg]
+		if !ok {
+			return nil, errors.Errorf("path segment %q not found", seg)
+		}
+		updated, err := applyJSONPatchOp(child, segments[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[seg] = updated
+		return n, nil
+
+	case []interface{}:
+		if len(segments) == 1 {
+			if seg == "-" {
+				if op != "add" {
+					return nil, errors.New(`"-" index is only valid for "add"`)
+				}
+				return append(n, value), nil
+			}
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx > len(n) {
+				return nil, errors.Errorf("invalid array index %q", seg)
+			}
+			switch op {
+			case "add":
+				n = append(n[:idx:idx], append([]interface{}{value}, n[idx:]...)...)
+			case "replace":
+				if idx == len(n) {
+					return nil, errors.Errorf("array index %d out of range", idx)
+				}
+				n[idx] = value
+			case "remove":
+				if idx == len(n) {
+					return nil, errors.Errorf("array index %d out of range", idx)
+				}
+				n = append(n[:idx], n[idx+1:]...)
+			default:
+				return nil, errors.Errorf("unsupported JSONPatch operation %q", op)
+			}
+			return n, nil
+		}
+		idx, err := strconv.Atoi(seg)
+		if err != nil || idx < 0 || idx >= len(n) {
+			return nil, errors.Errorf("invalid array index %q", seg)
+		}
+		updated, err := applyJSONPatchOp(n[idx], segments[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = updated
+		return n, nil
+
+	default:
+		return nil, errors.Errorf("cannot traverse into non-container at %q", seg)
+	}
+}
+
+// jsonPointerSegments splits an RFC 6901 JSON Pointer into its unescaped
+// segments, e.g. "/spec/containers/0/image" -> ["spec","containers","0","image"]
+func jsonPointerSegments(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	raw := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	segments := make([]string, len(raw))
+	for i, s := range raw {
+		s = strings.ReplaceAll(s, "~1", "/")
+		s = strings.ReplaceAll(s, "~0", "~")
+		segments[i] = s
+	}
+	return segments
+}
@@ -7,11 +7,17 @@ import (
 	operator_catalog "code.cloudfoundry.org/cf-operator/testing"
 	testing_utils "code.cloudfoundry.org/quarks-utils/testing"
 	"context"
+	"encoding/json"
 	eirinix "github.com/SUSE/eirinix"
 	"github.com/phayes/freeport"
 	"github.com/pkg/errors"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
 	"strconv"
 )
 
@@ -81,6 +87,22 @@ func (c *Catalog) IntegrationManagerFiltered(b bool, n string) eirinix.Manager {
 		})
 }
 
+// IntegrationManagerWithSelector returns an Extensions manager scoped to the given
+// ExtensionSelector, used by integration tests to assert that an extension only
+// fires on the intended subset of pods
+func (c *Catalog) IntegrationManagerWithSelector(sel eirinix.ExtensionSelector) eirinix.Manager {
+	return eirinix.NewManager(
+		eirinix.ManagerOptions{
+			Namespace:         "default",
+			Host:              c.KindHost,
+			Port:              c.ServicePort,
+			KubeConfig:        os.Getenv("KUBECONFIG"),
+			ServiceName:       "eirinix",
+			WebhookNamespace:  "default",
+			ExtensionSelector: sel,
+		})
+}
+
 // IntegrationManagerNoRegister returns an Extensions manager which is used by integration tests, which doesn't register extensions again
 func (c *Catalog) IntegrationManagerNoRegister() eirinix.Manager {
 	RegisterWebhooks := false
@@ -144,6 +166,44 @@ spec:
 `)
 }
 
+// EiriniAppYamlLabeled returns a fake Eirini app yaml carrying the given extra
+// labels and annotations, used to assert selector-based extension filtering
+func (c *Catalog) EiriniAppYamlLabeled(labels, annotations map[string]string) []byte {
+	podLabels := map[string]string{eirinix.LabelSourceType: "APP"}
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "eirini-fake-app",
+			Labels:      podLabels,
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers: []corev1.Container{
+				{
+					Name:    "eirini-fake-app",
+					Image:   "busybox:1.28.4",
+					Command: []string{"sleep", "3600"},
+					Env: []corev1.EnvVar{
+						{Name: "FAKE_APP", Value: "fake content"},
+					},
+				},
+			},
+		},
+	}
+	pod.APIVersion = "v1"
+	pod.Kind = "Pod"
+
+	out, err := yaml.Marshal(pod)
+	if err != nil {
+		panic(err) // pod is a compile-time struct, marshaling cannot fail
+	}
+	return out
+}
+
 // EiriniStagingAppYaml returns a fake Eirini staging app yaml
 func (c *Catalog) EiriniStagingAppYaml() []byte {
 	return []byte(`
@@ -173,29 +233,83 @@ func (c *Catalog) RegisterEiriniXService() error {
 	return nil
 }
 
+// MultiClusterIntegrationManager returns an eirinix.MultiClusterManager fanning
+// out a ManagerOptions per kubeconfig, used by integration tests that span more
+// than one cluster
+func (c *Catalog) MultiClusterIntegrationManager(kubeconfigs []string) eirinix.MultiClusterManager {
+	opts := make([]eirinix.ManagerOptions, len(kubeconfigs))
+	for i, kubeconfig := range kubeconfigs {
+		opts[i] = eirinix.ManagerOptions{
+			Namespace:        "default",
+			Host:             c.KindHost,
+			Port:             c.ServicePort,
+			KubeConfig:       kubeconfig,
+			ServiceName:      "eirinix",
+			WebhookNamespace: "default",
+		}
+	}
+	return eirinix.NewMultiClusterManager(opts...)
+}
+
+// RegisterEiriniXServiceInCluster register the service generated in ServiceYaml()
+// against the cluster identified by clusterID
+func (c *Catalog) RegisterEiriniXServiceInCluster(clusterID string) error {
+
+	err := KubeApplyCluster(clusterID, c.ServiceYaml())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EiriniApp represents a fake app pod started by one of the Catalog's
+// StartEiriniApp* helpers. ClusterID is empty for single-cluster usage and
+// set to the kubeconfig context name for pods started with
+// StartEiriniAppInCluster, so IsRunning/Delete act on the right cluster
 type EiriniApp struct {
-	Name, Namespace string
-	Pod             *Pod
+	Name, Namespace, ClusterID string
+	Pod                        *Pod
 }
 
-// StartEiriniApp starts EiriniAppYaml with kubernetes
+// IsRunning reports whether the pod is running, in the cluster identified by
+// ClusterID when set
 func (c *EiriniApp) IsRunning() (bool, error) {
-	p, err := KubePodStatus(c.Name, c.Namespace)
+	if c.ClusterID == "" {
+		p, err := KubePodStatus(c.Name, c.Namespace)
+		if err != nil {
+			return false, err
+		}
+		return p.IsRunning(), nil
+	}
+
+	phase, err := kubectlClusterPodPhase(c.ClusterID, c.Name, c.Namespace)
 	if err != nil {
 		return false, err
 	}
-	return p.IsRunning(), nil
+	return phase == "Running", nil
 }
 
+// Delete removes the pod, in the cluster identified by ClusterID when set
 func (c *EiriniApp) Delete() error {
-	out, err := Kubectl([]string{}, "delete", "pod", "-n", c.Namespace, c.Name)
+	flags := []string{}
+	if c.ClusterID != "" {
+		flags = []string{"--context", c.ClusterID}
+	}
+	out, err := Kubectl(flags, "delete", "pod", "-n", c.Namespace, c.Name)
 	if err != nil {
 		return errors.Wrap(err, "Failed: "+string(out))
 	}
 	return nil
 }
 
+// Sync refreshes Pod with the latest status. Only supported for
+// single-cluster EiriniApps; multi-cluster tests should use IsRunning, which
+// is cluster-aware
 func (c *EiriniApp) Sync() error {
+	if c.ClusterID != "" {
+		return errors.New("Sync is not supported for a multi-cluster EiriniApp, use IsRunning instead")
+	}
 	p, err := KubePodStatus(c.Name, c.Namespace)
 	if err != nil {
 		return err
@@ -248,6 +362,104 @@ func (c *Catalog) StartEiriniStagingAppInNamespace(n string) (*EiriniApp, error)
 	return &EiriniApp{Name: "6ad9f634-b32e-4890-b1ba-55202d95bc3a-xdcp6", Namespace: n}, nil
 }
 
+// StartEiriniAppInCluster starts EiriniAppYaml against the cluster identified by
+// clusterID, used by multi-cluster integration tests
+func (c *Catalog) StartEiriniAppInCluster(clusterID, ns string) (*EiriniApp, error) {
+
+	err := KubeApplyClusterNamespace(clusterID, c.EiriniAppYaml(), ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EiriniApp{Name: "eirini-fake-app", Namespace: ns, ClusterID: clusterID}, nil
+}
+
+// ExtensionConfigYaml returns the yaml of an ExtensionConfig custom resource with
+// the given name and spec, used by integration tests to register/reconcile
+// extensions declaratively
+func (c *Catalog) ExtensionConfigYaml(name string, spec eirinix.ExtensionConfigSpec) []byte {
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		panic(err) // marshaling fails only when spec.Config is not valid JSON
+	}
+	return []byte(`
+apiVersion: eirinix.suse.io/v1
+kind: ExtensionConfig
+metadata:
+  name: ` + name + `
+spec: ` + string(specJSON) + `
+`)
+}
+
+// RegisterExtensionConfig applies an ExtensionConfig yaml generated by
+// ExtensionConfigYaml to the cluster
+func (c *Catalog) RegisterExtensionConfig(name string, spec eirinix.ExtensionConfigSpec) error {
+	return KubeApply(c.ExtensionConfigYaml(name, spec))
+}
+
+// DeleteExtensionConfig removes the ExtensionConfig with the given name
+func (c *Catalog) DeleteExtensionConfig(name string) error {
+	out, err := Kubectl([]string{}, "delete", "extensionconfig", name)
+	if err != nil {
+		return errors.Wrap(err, "Failed: "+string(out))
+	}
+	return nil
+}
+
+// SimpleManagerWithCRDReconciler returns an Extensions manager with the
+// ExtensionConfig CRD reconciler enabled, used by integration tests to assert
+// that applying/removing a CR toggles a webhook live
+func (c *Catalog) SimpleManagerWithCRDReconciler() eirinix.Manager {
+	return eirinix.NewManager(
+		eirinix.ManagerOptions{
+			Namespace:                 "default",
+			Host:                      c.KindHost,
+			Port:                      c.ServicePort,
+			KubeConfig:                os.Getenv("KUBECONFIG"),
+			ServiceName:               "eirinix",
+			WebhookNamespace:          "default",
+			ReconcileExtensionConfigs: true,
+		})
+}
+
+// PluginDir creates and returns a fresh temporary directory to be used as the
+// plugin discovery directory for IntegrationManagerWithPlugins
+func (c *Catalog) PluginDir() (string, error) {
+	return ioutil.TempDir("", "eirinix-plugins")
+}
+
+// FakePluginBinary writes script as an executable plugin binary called name
+// inside dir, used by integration tests to verify out-of-process plugins get
+// invoked for matching pods. script is invoked by the Manager's plugin
+// registry as `name manifest` to probe it and `name mutate` (with a
+// JSON-encoded AdmissionRequest on stdin) for every matching pod, mirroring
+// eirinix.ServePlugin's protocol — script must dispatch on $1 itself, e.g.
+// a shebang line followed by a `case "$1" in manifest) ...; mutate) ...;
+// esac`. It does not need to start with a shebang, but without one it must
+// already be a valid executable for the test's GOOS/GOARCH
+func (c *Catalog) FakePluginBinary(dir, name, script string) (string, error) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		return "", errors.Wrap(err, "writing fake plugin binary")
+	}
+	return path, nil
+}
+
+// IntegrationManagerWithPlugins returns an Extensions manager which discovers
+// and invokes out-of-process plugins from dir, used by integration tests
+func (c *Catalog) IntegrationManagerWithPlugins(dir string) eirinix.Manager {
+	return eirinix.NewManager(
+		eirinix.ManagerOptions{
+			Namespace:        "default",
+			Host:             c.KindHost,
+			Port:             c.ServicePort,
+			KubeConfig:       os.Getenv("KUBECONFIG"),
+			ServiceName:      "eirinix",
+			WebhookNamespace: "default",
+			PluginDir:        dir,
+		})
+}
+
 // SimpleManagerService returns a dummy Extensions manager configured to run as a service
 func (c *Catalog) SimpleManagerService() eirinix.Manager {
 	return eirinix.NewManager(
@@ -0,0 +1,51 @@
+package testing
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KubeApplyCluster applies manifest against the cluster identified by
+// clusterID, which is passed to kubectl as its --context name
+func KubeApplyCluster(clusterID string, manifest []byte) error {
+	return kubectlApplyContext(clusterID, "", manifest)
+}
+
+// KubeApplyClusterNamespace applies manifest into namespace ns against the
+// cluster identified by clusterID
+func KubeApplyClusterNamespace(clusterID string, manifest []byte, ns string) error {
+	return kubectlApplyContext(clusterID, ns, manifest)
+}
+
+func kubectlApplyContext(clusterID, ns string, manifest []byte) error {
+	args := []string{}
+	if clusterID != "" {
+		args = append(args, "--context", clusterID)
+	}
+	if ns != "" {
+		args = append(args, "-n", ns)
+	}
+	args = append(args, "apply", "-f", "-")
+
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stdin = bytes.NewReader(manifest)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, "kubectl apply failed: "+string(out))
+	}
+	return nil
+}
+
+// kubectlClusterPodPhase returns the .status.phase of pod name in namespace
+// ns on the cluster identified by clusterID
+func kubectlClusterPodPhase(clusterID, name, ns string) (string, error) {
+	out, err := Kubectl([]string{"--context", clusterID},
+		"get", "pod", name, "-n", ns, "-o", "jsonpath={.status.phase}")
+	if err != nil {
+		return "", errors.Wrap(err, "Failed: "+string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
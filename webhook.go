@@ -0,0 +1,95 @@
+package eirinix
+
+import (
+	"context"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// webhookRule describes one entry eiriniX contributes to the
+// MutatingWebhookConfiguration: either the Manager's own static
+// ExtensionSelector-scoped entry, or one per ExtensionConfig CR the
+// reconciler has loaded
+type webhookRule struct {
+	name               string
+	path               string
+	selector           ExtensionSelector
+	failurePolicy      admissionregistrationv1.FailurePolicyType
+	reinvocationPolicy admissionregistrationv1.ReinvocationPolicyType
+}
+
+// buildWebhookConfiguration assembles the MutatingWebhookConfiguration for
+// opts.ServiceName out of rules, translating each rule's ExtensionSelector
+// into an ObjectSelector where possible (MatchLabels/MatchExpressions); the
+// MatchAnnotations part has no webhook-level equivalent and is enforced
+// in-process on the admission path instead, see ExtensionSelector.Matches
+func buildWebhookConfiguration(opts ManagerOptions, rules []webhookRule) *admissionregistrationv1.MutatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	scope := admissionregistrationv1.AllScopes
+
+	webhooks := make([]admissionregistrationv1.MutatingWebhook, 0, len(rules))
+	for _, rule := range rules {
+		failurePolicy := rule.failurePolicy
+		if failurePolicy == "" {
+			failurePolicy = admissionregistrationv1.Fail
+		}
+		reinvocationPolicy := rule.reinvocationPolicy
+		if reinvocationPolicy == "" {
+			reinvocationPolicy = admissionregistrationv1.Never
+		}
+
+		webhooks = append(webhooks, admissionregistrationv1.MutatingWebhook{
+			Name:                    rule.name + "." + opts.ServiceName + ".eirinix.suse.io",
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects:             &sideEffects,
+			FailurePolicy:           &failurePolicy,
+			ReinvocationPolicy:      &reinvocationPolicy,
+			ObjectSelector:          rule.selector.ObjectSelector(),
+			NamespaceSelector:       rule.selector.NamespaceSelector(),
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Name:      opts.ServiceName,
+					Namespace: opts.WebhookNamespace,
+					Path:      &rule.path,
+				},
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"pods"},
+						Scope:       &scope,
+					},
+				},
+			},
+		})
+	}
+
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: opts.ServiceName,
+		},
+		Webhooks: webhooks,
+	}
+}
+
+// applyWebhookConfiguration creates cfg, or updates the existing
+// MutatingWebhookConfiguration of the same name in place (preserving its
+// ResourceVersion) when it already exists
+func applyWebhookConfiguration(ctx context.Context, client kubernetes.Interface, cfg *admissionregistrationv1.MutatingWebhookConfiguration) error {
+	webhooks := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	existing, err := webhooks.Get(ctx, cfg.Name, metav1.GetOptions{})
+	if err != nil {
+		_, err = webhooks.Create(ctx, cfg, metav1.CreateOptions{})
+		return err
+	}
+
+	cfg.ResourceVersion = existing.ResourceVersion
+	_, err = webhooks.Update(ctx, cfg, metav1.UpdateOptions{})
+	return err
+}